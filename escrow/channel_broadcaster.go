@@ -0,0 +1,195 @@
+package escrow
+
+import (
+	"context"
+
+	"github.com/singnet/snet-daemon/etcddb"
+	log "github.com/sirupsen/logrus"
+)
+
+// PaymentChannelUpdate is delivered to subscribers every time the stored
+// state for a channel changes, so they can react to e.g. AuthorizedAmount
+// moving up without polling Get in a loop.
+type PaymentChannelUpdate struct {
+	Key     *PaymentChannelKey
+	Channel *PaymentChannelData
+	// Removed is true when the channel entry was deleted rather than
+	// updated (e.g. after the channel is closed and garbage collected).
+	Removed bool
+	// Revision is the etcd ModRevision this update was read at. Pass it to
+	// SubscribeChannelUpdatesFromRevision/SubscribeAllChannelUpdatesFromRevision
+	// to resume a subscription (e.g. after a process restart) without
+	// missing or re-delivering updates.
+	Revision int64
+}
+
+// CancelFunc stops a subscription created by SubscribeChannelUpdates and
+// releases the underlying watch.
+type CancelFunc func()
+
+// SubscribeChannelUpdates streams PaymentChannelUpdate values for key until
+// the returned CancelFunc is called. It is implemented on top of
+// EtcdClient.Watch, so the same reconnection/resume guarantees apply:
+// delivery survives a dropped connection to the etcd cluster.
+func (storage *EtcdStorage) SubscribeChannelUpdates(key *PaymentChannelKey) (<-chan PaymentChannelUpdate, CancelFunc) {
+	return storage.SubscribeChannelUpdatesFromRevision(key, 0)
+}
+
+// SubscribeChannelUpdatesFromRevision is like SubscribeChannelUpdates but
+// resumes from fromRevision (typically a PaymentChannelUpdate.Revision
+// seen by an earlier, now-stopped subscription), so a restarted subscriber
+// does not miss updates that landed while it was down. fromRevision == 0
+// behaves like SubscribeChannelUpdates.
+func (storage *EtcdStorage) SubscribeChannelUpdatesFromRevision(key *PaymentChannelKey, fromRevision int64) (<-chan PaymentChannelUpdate, CancelFunc) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	watchEvents, err := storage.client.WatchFromRevision(ctx, paymentChannelStorageKey(key), fromRevision)
+	if err != nil {
+		cancel()
+		updates := make(chan PaymentChannelUpdate)
+		close(updates)
+		return updates, func() {}
+	}
+
+	return convertWatchEvents(watchEvents, func(storageKey string) (*PaymentChannelKey, error) { return key, nil }), cancel
+}
+
+// SubscribeAllChannelUpdates streams PaymentChannelUpdate values for every
+// channel in storage until the returned CancelFunc is called. It backs
+// SettlementDriver, which otherwise has no way to learn about a channel
+// being locked without polling every key.
+func (storage *EtcdStorage) SubscribeAllChannelUpdates() (<-chan PaymentChannelUpdate, CancelFunc) {
+	return storage.SubscribeAllChannelUpdatesFromRevision(0)
+}
+
+// SubscribeAllChannelUpdatesFromRevision is the SubscribeAllChannelUpdates
+// counterpart of SubscribeChannelUpdatesFromRevision.
+func (storage *EtcdStorage) SubscribeAllChannelUpdatesFromRevision(fromRevision int64) (<-chan PaymentChannelUpdate, CancelFunc) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	watchEvents, err := storage.client.WatchByPrefixFromRevision(ctx, etcdStorageKeyPrefix, fromRevision)
+	if err != nil {
+		cancel()
+		updates := make(chan PaymentChannelUpdate)
+		close(updates)
+		return updates, func() {}
+	}
+
+	return convertWatchEvents(watchEvents, parsePaymentChannelStorageKey), cancel
+}
+
+// convertWatchEvents adapts a raw etcddb watch stream into typed
+// PaymentChannelUpdate values, deriving the channel key via keyOf (either
+// a closure returning a key already known to the caller, or
+// parsePaymentChannelStorageKey for a prefix watch).
+func convertWatchEvents(watchEvents <-chan etcddb.WatchEvent, keyOf func(storageKey string) (*PaymentChannelKey, error)) <-chan PaymentChannelUpdate {
+
+	updates := make(chan PaymentChannelUpdate)
+
+	go func() {
+		defer close(updates)
+		for event := range watchEvents {
+			key, err := keyOf(event.Key)
+			if err != nil {
+				continue
+			}
+
+			update := PaymentChannelUpdate{Key: key, Revision: event.ModRevision}
+
+			if event.Type == etcddb.WatchEventDelete {
+				update.Removed = true
+				updates <- update
+				continue
+			}
+
+			channel := &PaymentChannelData{}
+			if err := deserialize(event.Value, channel); err != nil {
+				continue
+			}
+			update.Channel = channel
+			updates <- update
+		}
+	}()
+
+	return updates
+}
+
+// ChannelBroadcaster fans a single upstream subscription out to any number
+// of in-process listeners (metering, pricing, gRPC interceptors), so that
+// each interested component does not open its own etcd watch.
+type ChannelBroadcaster struct {
+	storage     *EtcdStorage
+	key         *PaymentChannelKey
+	cancel      CancelFunc
+	subscribe   chan chan<- PaymentChannelUpdate
+	unsubscribe chan chan<- PaymentChannelUpdate
+}
+
+// NewChannelBroadcaster starts watching key and returns a broadcaster ready
+// to accept listeners via Subscribe.
+func NewChannelBroadcaster(storage *EtcdStorage, key *PaymentChannelKey) *ChannelBroadcaster {
+
+	updates, cancel := storage.SubscribeChannelUpdates(key)
+
+	broadcaster := &ChannelBroadcaster{
+		storage:     storage,
+		key:         key,
+		cancel:      cancel,
+		subscribe:   make(chan chan<- PaymentChannelUpdate),
+		unsubscribe: make(chan chan<- PaymentChannelUpdate),
+	}
+
+	go broadcaster.run(updates)
+
+	return broadcaster
+}
+
+func (broadcaster *ChannelBroadcaster) run(updates <-chan PaymentChannelUpdate) {
+	listeners := make(map[chan<- PaymentChannelUpdate]bool)
+
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				for listener := range listeners {
+					close(listener)
+				}
+				return
+			}
+			// A listener's channel is owned by the caller, so a full or
+			// unread one must not block the whole broadcast loop: that
+			// would stall every other listener and make Subscribe/Close
+			// (which also go through this select) hang. Drop the update
+			// for that listener instead.
+			for listener := range listeners {
+				select {
+				case listener <- update:
+				default:
+					log.WithField("key", broadcaster.key).Warn("dropping channel update for a slow subscriber")
+				}
+			}
+		case listener := <-broadcaster.subscribe:
+			listeners[listener] = true
+		case listener := <-broadcaster.unsubscribe:
+			delete(listeners, listener)
+		}
+	}
+}
+
+// Subscribe registers listener to receive every future PaymentChannelUpdate.
+// Call the returned CancelFunc to stop delivery and release listener. Pass
+// a buffered channel if the listener cannot guarantee it reads promptly:
+// run drops updates rather than blocking on a full or unread listener.
+func (broadcaster *ChannelBroadcaster) Subscribe(listener chan<- PaymentChannelUpdate) CancelFunc {
+	broadcaster.subscribe <- listener
+	return func() {
+		broadcaster.unsubscribe <- listener
+	}
+}
+
+// Close stops the upstream watch and disconnects all listeners.
+func (broadcaster *ChannelBroadcaster) Close() {
+	broadcaster.cancel()
+}
@@ -0,0 +1,40 @@
+package escrow
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeChannelUpdates(t *testing.T) {
+
+	close, e := initEtcdStorage()
+	if e != nil {
+		t.Errorf("error during etcd storage initialization: %v", e)
+	}
+	defer close()
+
+	storage := etcdStorageMock.EtcdStorage
+	key := newPaymentChannelKey(1, 0)
+
+	updates, cancel := storage.SubscribeChannelUpdates(key)
+	defer cancel()
+
+	channel := &PaymentChannelData{
+		State:            Open,
+		FullAmount:       big.NewInt(100),
+		AuthorizedAmount: big.NewInt(10),
+	}
+	err := storage.Put(key, channel)
+	assert.Nil(t, err)
+
+	select {
+	case update := <-updates:
+		assert.False(t, update.Removed)
+		assert.Equal(t, channel.AuthorizedAmount, update.Channel.AuthorizedAmount)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for channel update")
+	}
+}
@@ -0,0 +1,172 @@
+package escrow
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/singnet/snet-daemon/etcddb"
+	"github.com/spf13/viper"
+)
+
+// PaymentChannelState is the lifecycle state of a payment channel as
+// tracked by the daemon, independent of the on-chain MultiPartyEscrow
+// contract state.
+type PaymentChannelState int
+
+const (
+	// Open means the channel can still accept new authorized payments.
+	Open PaymentChannelState = iota
+	// Closed means the channel has been settled on-chain and must not
+	// accept further payments.
+	Closed
+	// Locked means a HashedTimeLock has reserved part of the channel's
+	// AuthorizedAmount for an in-flight off-chain-to-on-chain settlement.
+	// See htlc.go.
+	Locked
+	// Settled means a locked settlement was claimed on-chain.
+	Settled
+	// TimedOut means a locked settlement expired before being claimed and
+	// is waiting for Reopen to return the channel to Open.
+	TimedOut
+)
+
+// PaymentChannelKey uniquely identifies a payment channel by its on-chain
+// id and the nonce of the current channel "epoch" (channels are reused
+// across nonces when extended/topped-up on-chain).
+type PaymentChannelKey struct {
+	ID    *big.Int
+	Nonce *big.Int
+}
+
+func newPaymentChannelKey(id int64, nonce int64) *PaymentChannelKey {
+	return &PaymentChannelKey{
+		ID:    big.NewInt(id),
+		Nonce: big.NewInt(nonce),
+	}
+}
+
+// PaymentChannelData is the value stored per PaymentChannelKey: everything
+// the daemon needs to authorize the next payment without talking to the
+// blockchain.
+type PaymentChannelData struct {
+	State            PaymentChannelState
+	Sender           *ecdsa.PublicKey
+	FullAmount       *big.Int
+	Expiration       time.Time
+	AuthorizedAmount *big.Int
+	Signature        []byte
+
+	// Lock is non-nil while State is Locked, Settled or TimedOut: it
+	// records the in-flight (or most recently resolved) HTLC-style
+	// settlement. See htlc.go.
+	Lock *HashedTimeLock
+
+	// version is the etcd version of the key this state was read from. It
+	// is populated by Get and consumed by CompareAndSwap/CompareAndDelete
+	// so those can compare on version instead of re-serializing and
+	// shipping the (potentially large) value just to check it is
+	// unchanged. Zero value for state that was never Get from storage.
+	version int64
+}
+
+const etcdStorageKeyPrefix = "/payment-channel/"
+
+func paymentChannelStorageKey(key *PaymentChannelKey) string {
+	return fmt.Sprintf("%v%v/%v", etcdStorageKeyPrefix, key.ID, key.Nonce)
+}
+
+// parsePaymentChannelStorageKey inverts paymentChannelStorageKey, e.g. to
+// recover the channel a prefix watch event belongs to.
+func parsePaymentChannelStorageKey(storageKey string) (key *PaymentChannelKey, err error) {
+	var id, nonce big.Int
+	if _, err = fmt.Sscanf(storageKey, etcdStorageKeyPrefix+"%v/%v", &id, &nonce); err != nil {
+		return nil, fmt.Errorf("unable to parse payment channel storage key %q: %v", storageKey, err)
+	}
+	return &PaymentChannelKey{ID: &id, Nonce: &nonce}, nil
+}
+
+func serialize(value interface{}) (json string, err error) {
+	bytes, err := json.Marshal(value)
+	return string(bytes), err
+}
+
+func deserialize(value string, target interface{}) (err error) {
+	return json.Unmarshal([]byte(value), target)
+}
+
+// EtcdStorage is a PaymentChannelStorage backed by an etcd cluster, so that
+// channel state is shared and consistent across a fleet of daemon replicas
+// fronting the same service.
+type EtcdStorage struct {
+	client *etcddb.EtcdClient
+}
+
+// NewEtcdStorage creates an EtcdStorage connected as configured in the
+// payment_channel_storage_client section of vip.
+func NewEtcdStorage(vip *viper.Viper) (storage *EtcdStorage, err error) {
+	client, err := etcddb.NewEtcdClientFromVip(vip)
+	if err != nil {
+		return
+	}
+	return &EtcdStorage{client: client}, nil
+}
+
+// Close releases the underlying etcd client.
+func (storage *EtcdStorage) Close() {
+	storage.client.Close()
+}
+
+// Get returns the channel state stored under key.
+func (storage *EtcdStorage) Get(key *PaymentChannelKey) (state *PaymentChannelData, ok bool, err error) {
+	value, version, ok, err := storage.client.GetWithVersion(paymentChannelStorageKey(key))
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	state = &PaymentChannelData{}
+	if err = deserialize(value, state); err != nil {
+		return nil, false, fmt.Errorf("unable to deserialize payment channel state: %v", err)
+	}
+	state.version = version
+	return state, true, nil
+}
+
+// Put unconditionally stores state under key.
+func (storage *EtcdStorage) Put(key *PaymentChannelKey, state *PaymentChannelData) (err error) {
+	value, err := serialize(state)
+	if err != nil {
+		return fmt.Errorf("unable to serialize payment channel state: %v", err)
+	}
+	return storage.client.Put(paymentChannelStorageKey(key), value)
+}
+
+// CompareAndSwap stores newState under key only if the key's version still
+// matches the one prevState was Get from. Comparing on version rather than
+// the full value avoids re-serializing PaymentChannelData, which can be
+// large once a channel has accumulated a long signature history.
+func (storage *EtcdStorage) CompareAndSwap(key *PaymentChannelKey, prevState *PaymentChannelData, newState *PaymentChannelData) (ok bool, err error) {
+	newValue, err := serialize(newState)
+	if err != nil {
+		return false, fmt.Errorf("unable to serialize new payment channel state: %v", err)
+	}
+
+	storageKey := paymentChannelStorageKey(key)
+	return storage.client.Txn().
+		If(etcddb.CompareVersion(storageKey, "=", prevState.version)).
+		Then(etcddb.OpPut(storageKey, newValue)).
+		Commit()
+}
+
+// CompareAndDelete removes key's channel entry if the key's version still
+// matches the one prevState was Get from, e.g. to close a channel that has
+// not been concurrently updated since it was last read.
+func (storage *EtcdStorage) CompareAndDelete(key *PaymentChannelKey, prevState *PaymentChannelData) (ok bool, err error) {
+	storageKey := paymentChannelStorageKey(key)
+	return storage.client.Txn().
+		If(etcddb.CompareVersion(storageKey, "=", prevState.version)).
+		Then(etcddb.OpDelete(storageKey)).
+		Commit()
+}
@@ -0,0 +1,123 @@
+package escrow
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// HashedTimeLock reserves part of a channel's AuthorizedAmount for an
+// off-chain-to-on-chain settlement without closing the channel, mirroring
+// the hashed-timelock-contract pattern used by Lightning-style atomic
+// swaps: the counter-party claims SwapAmount on-chain by revealing a
+// preimage hashing to PreimageHash before TimeoutBlock, or the lock
+// reverts and the channel keeps operating normally.
+type HashedTimeLock struct {
+	PreimageHash []byte
+	TimeoutBlock uint64
+	SwapAmount   *big.Int
+}
+
+func hashPreimage(preimage []byte) []byte {
+	sum := sha256.Sum256(preimage)
+	return sum[:]
+}
+
+// LockForSettlement reserves lock.SwapAmount on key's channel: it only
+// succeeds if the channel is currently Open and its AuthorizedAmount is at
+// least lock.SwapAmount. It reuses CompareAndSwap's version-based
+// transaction, so a concurrent payment authorization racing the lock
+// attempt makes exactly one of them win.
+func LockForSettlement(storage *EtcdStorage, key *PaymentChannelKey, lock *HashedTimeLock) (ok bool, err error) {
+	state, ok, err := storage.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if state.State != Open {
+		return false, nil
+	}
+	if state.AuthorizedAmount.Cmp(lock.SwapAmount) < 0 {
+		return false, nil
+	}
+
+	newState := *state
+	newState.State = Locked
+	newState.Lock = lock
+
+	return storage.CompareAndSwap(key, state, &newState)
+}
+
+// SettleLock claims a Locked settlement by revealing preimage. It fails if
+// the channel is not Locked, or if preimage does not hash to the
+// PreimageHash recorded by LockForSettlement.
+func SettleLock(storage *EtcdStorage, key *PaymentChannelKey, preimage []byte) (ok bool, err error) {
+	state, ok, err := storage.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if state.State != Locked {
+		return false, nil
+	}
+	if !bytes.Equal(hashPreimage(preimage), state.Lock.PreimageHash) {
+		return false, fmt.Errorf("preimage does not match the locked hash for channel %v/%v", key.ID, key.Nonce)
+	}
+
+	newState := *state
+	newState.State = Settled
+	newState.AuthorizedAmount = new(big.Int).Sub(state.AuthorizedAmount, state.Lock.SwapAmount)
+
+	return storage.CompareAndSwap(key, state, &newState)
+}
+
+// ExpireLock moves a Locked channel to TimedOut once its lock's
+// TimeoutBlock has passed without the preimage being revealed. currentBlock
+// is the caller's view of the current chain height.
+func ExpireLock(storage *EtcdStorage, key *PaymentChannelKey, currentBlock uint64) (ok bool, err error) {
+	state, ok, err := storage.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if state.State != Locked {
+		return false, nil
+	}
+	if currentBlock < state.Lock.TimeoutBlock {
+		return false, nil
+	}
+
+	newState := *state
+	newState.State = TimedOut
+
+	return storage.CompareAndSwap(key, state, &newState)
+}
+
+// Reopen returns a TimedOut channel to Open, clearing its expired lock, so
+// the channel can accept payments and settlement attempts again. It takes
+// an etcd mutex scoped to key so that concurrent clients racing to reopen
+// the same channel serialize instead of double-applying the transition.
+func Reopen(storage *EtcdStorage, key *PaymentChannelKey) (ok bool, err error) {
+	mutex, err := storage.client.NewMutex(paymentChannelStorageKey(key) + "/reopen")
+	if err != nil {
+		return false, fmt.Errorf("unable to create reopen mutex: %v", err)
+	}
+	ctx := context.Background()
+	if err = mutex.Lock(ctx); err != nil {
+		return false, fmt.Errorf("unable to lock reopen mutex: %v", err)
+	}
+	defer mutex.Unlock(ctx)
+
+	state, ok, err := storage.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if state.State != TimedOut {
+		return false, nil
+	}
+
+	newState := *state
+	newState.State = Open
+	newState.Lock = nil
+
+	return storage.CompareAndSwap(key, state, &newState)
+}
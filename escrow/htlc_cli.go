@@ -0,0 +1,33 @@
+package escrow
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Uncharge triggers an off-chain-to-on-chain settlement of amount from
+// channel key, mirroring Lightning Loop's `swapcli uncharge <amount>`.
+// preimageHash/timeoutBlock are chosen by the caller's swap provider
+// negotiation and passed straight through to LockForSettlement.
+//
+// This repo slice has no daemon CLI/cmd package for Uncharge to register
+// itself against, so it stops at this plain exported function; wiring an
+// actual `swapcli uncharge`-style subcommand is for whichever package ends
+// up owning the daemon's command-line surface.
+func Uncharge(storage *EtcdStorage, key *PaymentChannelKey, amount *big.Int, preimageHash []byte, timeoutBlock uint64) (err error) {
+
+	lock := &HashedTimeLock{
+		PreimageHash: preimageHash,
+		TimeoutBlock: timeoutBlock,
+		SwapAmount:   amount,
+	}
+
+	ok, err := LockForSettlement(storage, key, lock)
+	if err != nil {
+		return fmt.Errorf("unable to lock channel %v/%v for settlement: %v", key.ID, key.Nonce, err)
+	}
+	if !ok {
+		return fmt.Errorf("channel %v/%v is not open or does not have enough authorized amount to swap %v", key.ID, key.Nonce, amount)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package escrow
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHtlcSettleWalk walks the Open -> Locked -> Settled path: a swap is
+// locked against an open channel, then claimed by revealing the preimage.
+func TestHtlcSettleWalk(t *testing.T) {
+
+	close, e := initEtcdStorage()
+	if e != nil {
+		t.Errorf("error during etcd storage initialization: %v", e)
+	}
+	defer close()
+
+	storage := etcdStorageMock.EtcdStorage
+	key := newPaymentChannelKey(1, 0)
+
+	err := storage.Put(key, &PaymentChannelData{
+		State:            Open,
+		FullAmount:       big.NewInt(100),
+		AuthorizedAmount: big.NewInt(50),
+	})
+	assert.Nil(t, err)
+
+	preimage := []byte("swap-secret")
+	hash := sha256.Sum256(preimage)
+
+	err = Uncharge(storage, key, big.NewInt(20), hash[:], 1000)
+	assert.Nil(t, err)
+
+	channel, ok, err := storage.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Locked, channel.State)
+	assert.Equal(t, big.NewInt(20), channel.Lock.SwapAmount)
+
+	ok, err = SettleLock(storage, key, []byte("wrong-secret"))
+	assert.NotNil(t, err, "a wrong preimage must be rejected")
+	assert.False(t, ok)
+
+	ok, err = SettleLock(storage, key, preimage)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	channel, ok, err = storage.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Settled, channel.State)
+	assert.Equal(t, big.NewInt(30), channel.AuthorizedAmount)
+}
+
+// TestHtlcTimeoutAndReopenUnderContention walks the Open -> Locked ->
+// TimedOut -> Open path, with several clients racing Reopen once the lock
+// has expired. NewMutex must ensure exactly one of them performs the
+// TimedOut -> Open transition.
+func TestHtlcTimeoutAndReopenUnderContention(t *testing.T) {
+
+	close, e := initEtcdStorage()
+	if e != nil {
+		t.Errorf("error during etcd storage initialization: %v", e)
+	}
+	defer close()
+
+	storage := etcdStorageMock.EtcdStorage
+	key := newPaymentChannelKey(2, 0)
+
+	err := storage.Put(key, &PaymentChannelData{
+		State:            Open,
+		FullAmount:       big.NewInt(100),
+		AuthorizedAmount: big.NewInt(50),
+	})
+	assert.Nil(t, err)
+
+	hash := sha256.Sum256([]byte("never-revealed"))
+	ok, err := LockForSettlement(storage, key, &HashedTimeLock{
+		PreimageHash: hash[:],
+		TimeoutBlock: 10,
+		SwapAmount:   big.NewInt(20),
+	})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = ExpireLock(storage, key, 11)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	channel, ok, err := storage.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, TimedOut, channel.State)
+
+	n := 5
+	var wg sync.WaitGroup
+	wg.Add(n)
+	results := make([]bool, n)
+
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			ok, err := Reopen(storage, key)
+			assert.Nil(t, err)
+			results[i] = ok
+		}(i)
+	}
+	wg.Wait()
+
+	reopened := 0
+	for _, ok := range results {
+		if ok {
+			reopened++
+		}
+	}
+	assert.Equal(t, 1, reopened, "exactly one concurrent Reopen must win")
+
+	channel, ok, err = storage.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Open, channel.State)
+	assert.Nil(t, channel.Lock)
+}
@@ -0,0 +1,134 @@
+package escrow
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OnChainWatcher observes claims against a HashedTimeLock on-chain. No
+// implementation is provided here; the caller injects one (e.g. reading
+// MultiPartyEscrow claim events over the configured blockchain RPC) so
+// SettlementDriver stays chain-agnostic.
+type OnChainWatcher interface {
+	// WatchClaim delivers the revealed preimage once preimageHash is
+	// claimed on-chain. The channel is closed if ctx is done first.
+	WatchClaim(ctx context.Context, preimageHash []byte) (<-chan []byte, error)
+	// BlockHeight returns the current on-chain block height, used to
+	// decide whether a lock's TimeoutBlock has passed.
+	BlockHeight(ctx context.Context) (uint64, error)
+}
+
+// blockPollInterval is how often SettlementDriver checks pending locks for
+// an elapsed timeout while waiting for an on-chain claim.
+const blockPollInterval = 15 * time.Second
+
+// SettlementDriver watches Locked channels and resolves them to Settled
+// (claim observed on-chain) or TimedOut (TimeoutBlock elapsed first).
+type SettlementDriver struct {
+	storage *EtcdStorage
+	watcher OnChainWatcher
+
+	// inFlight tracks the channels currently under supervision, keyed by
+	// paymentChannelStorageKey. SubscribeAllChannelUpdates can deliver more
+	// than one Locked update for the same channel (e.g. a further write to a
+	// channel that is still Locked), and without this a second superviseLock
+	// goroutine would race the first one over the same HTLC.
+	inFlightMutex sync.Mutex
+	inFlight      map[string]bool
+}
+
+// NewSettlementDriver creates a SettlementDriver for storage's channels,
+// resolving locks using watcher.
+func NewSettlementDriver(storage *EtcdStorage, watcher OnChainWatcher) *SettlementDriver {
+	return &SettlementDriver{
+		storage:  storage,
+		watcher:  watcher,
+		inFlight: make(map[string]bool),
+	}
+}
+
+// Run watches every Locked channel under storage until ctx is done,
+// settling or expiring each one as its HTLC resolves. It is intended to be
+// run once, in its own goroutine, for the lifetime of the daemon.
+func (driver *SettlementDriver) Run(ctx context.Context) {
+
+	updates, cancel := driver.storage.SubscribeAllChannelUpdates()
+	defer cancel()
+
+	// Stopping the subscription once ctx is done closes updates, which
+	// ends the loop below.
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+
+	for event := range updates {
+		if event.Removed || event.Channel.State != Locked {
+			continue
+		}
+
+		storageKey := paymentChannelStorageKey(event.Key)
+
+		driver.inFlightMutex.Lock()
+		alreadySupervised := driver.inFlight[storageKey]
+		driver.inFlight[storageKey] = true
+		driver.inFlightMutex.Unlock()
+
+		if alreadySupervised {
+			continue
+		}
+
+		go driver.superviseLock(ctx, storageKey, event.Key, event.Channel.Lock)
+	}
+}
+
+func (driver *SettlementDriver) superviseLock(ctx context.Context, storageKey string, key *PaymentChannelKey, lock *HashedTimeLock) {
+	defer func() {
+		driver.inFlightMutex.Lock()
+		delete(driver.inFlight, storageKey)
+		driver.inFlightMutex.Unlock()
+	}()
+
+	claims, err := driver.watcher.WatchClaim(ctx, lock.PreimageHash)
+	if err != nil {
+		log.WithError(err).WithField("channel", key).Error("unable to watch on-chain claim for locked channel")
+		return
+	}
+
+	ticker := time.NewTicker(blockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case preimage := <-claims:
+			if ok, err := SettleLock(driver.storage, key, preimage); err != nil {
+				log.WithError(err).WithField("channel", key).Error("unable to settle locked channel")
+			} else if ok {
+				log.WithField("channel", key).Info("settled locked channel from on-chain claim")
+			}
+			return
+
+		case <-ticker.C:
+			height, err := driver.watcher.BlockHeight(ctx)
+			if err != nil {
+				log.WithError(err).Warn("unable to read on-chain block height")
+				continue
+			}
+			if height < lock.TimeoutBlock {
+				continue
+			}
+			if ok, err := ExpireLock(driver.storage, key, height); err != nil {
+				log.WithError(err).WithField("channel", key).Error("unable to expire locked channel")
+			} else if ok {
+				log.WithField("channel", key).Info("locked channel timed out, awaiting Reopen")
+			}
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
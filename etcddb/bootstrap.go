@@ -0,0 +1,86 @@
+package etcddb
+
+import (
+	"errors"
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+// BootstrapRoleName is the etcd role granted to snet-daemon instances
+// sharing a multi-tenant cluster. It is scoped to keyPrefix so that a
+// compromised daemon cannot read or write another tenant's channel state.
+const BootstrapRoleName = "snet-daemon-channel-state"
+
+// rootUsername is etcd's built-in superuser name. A "root" user granted
+// the built-in "root" role must exist before AuthEnable succeeds; etcd
+// refuses to turn auth on otherwise.
+const rootUsername = "root"
+const rootRoleName = "root"
+
+// Bootstrap creates (or updates) the snet-daemon role and user on an etcd
+// cluster reachable through client, restricting them to read/write access
+// on keyPrefix. It mirrors what operators would otherwise do by hand with
+// `etcdctl role add` / `etcdctl user add`, so that a shared cluster can be
+// provisioned for a new daemon without granting root access.
+//
+// client must be talking to a cluster that does not have auth enabled yet.
+// Bootstrap creates the root user (with rootPassword) alongside the
+// snet-daemon user and then enables auth, so it is only safe to call once
+// per cluster; running it again requires client to already authenticate
+// as root.
+func Bootstrap(client *EtcdClient, username string, password string, keyPrefix string, rootPassword string) (err error) {
+
+	ctx, cancel := client.context()
+	defer cancel()
+
+	auth := client.etcdv3.Auth
+
+	if _, err = auth.RoleAdd(ctx, BootstrapRoleName); err != nil && !isAlreadyExistsErr(err) {
+		return fmt.Errorf("unable to create role %v: %v", BootstrapRoleName, err)
+	}
+
+	if _, err = auth.RoleGrantPermission(
+		ctx,
+		BootstrapRoleName,
+		keyPrefix,
+		clientv3.GetPrefixRangeEnd(keyPrefix),
+		clientv3.PermissionType(clientv3.PermReadWrite),
+	); err != nil {
+		return fmt.Errorf("unable to grant permission on prefix %v to role %v: %v", keyPrefix, BootstrapRoleName, err)
+	}
+
+	if _, err = auth.UserAdd(ctx, username, password); err != nil && !isAlreadyExistsErr(err) {
+		return fmt.Errorf("unable to create user %v: %v", username, err)
+	}
+
+	if _, err = auth.UserGrantRole(ctx, username, BootstrapRoleName); err != nil {
+		return fmt.Errorf("unable to grant role %v to user %v: %v", BootstrapRoleName, username, err)
+	}
+
+	// etcd refuses AuthEnable without an existing root user granted the
+	// root role, so provision one before flipping auth on.
+	if _, err = auth.UserAdd(ctx, rootUsername, rootPassword); err != nil && !isAlreadyExistsErr(err) {
+		return fmt.Errorf("unable to create root user: %v", err)
+	}
+
+	if _, err = auth.UserGrantRole(ctx, rootUsername, rootRoleName); err != nil {
+		return fmt.Errorf("unable to grant role %v to user %v: %v", rootRoleName, rootUsername, err)
+	}
+
+	if _, err = auth.AuthEnable(ctx); err != nil {
+		return fmt.Errorf("unable to enable auth: %v", err)
+	}
+
+	return nil
+}
+
+// isAlreadyExistsErr reports whether err is the role/user-already-exists
+// error etcd returns, so Bootstrap can be re-run idempotently. It compares
+// against the client-facing rpctypes sentinel errors rather than matching
+// error strings, which etcd does not guarantee to keep stable across
+// versions.
+func isAlreadyExistsErr(err error) bool {
+	return errors.Is(err, rpctypes.ErrRoleAlreadyExist) || errors.Is(err, rpctypes.ErrUserAlreadyExist)
+}
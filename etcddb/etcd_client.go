@@ -0,0 +1,324 @@
+package etcddb
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+	"go.etcd.io/etcd/pkg/transport"
+)
+
+// EtcdClientConf keeps the configuration for etcd client
+type EtcdClientConf struct {
+	ConnectionTimeout time.Duration `json:"connection_timeout" mapstructure:"CONNECTION_TIMEOUT"`
+	RequestTimeout    time.Duration `json:"request_timeout" mapstructure:"REQUEST_TIMEOUT"`
+	Endpoints         []string      `json:"endpoints" mapstructure:"ENDPOINTS"`
+
+	// CAFile, CertFile and KeyFile enable client TLS; setting any of them
+	// switches the client to TLS. CertFile and KeyFile are needed together
+	// to present a client certificate; CAFile alone is enough to only verify
+	// the server certificate without presenting a client certificate.
+	CAFile   string `json:"ca_file" mapstructure:"CA_FILE"`
+	CertFile string `json:"cert_file" mapstructure:"CERT_FILE"`
+	KeyFile  string `json:"key_file" mapstructure:"KEY_FILE"`
+
+	// Username and Password enable etcd's RBAC authentication on top of
+	// (or instead of) TLS.
+	Username string `json:"username" mapstructure:"USERNAME"`
+	Password string `json:"password" mapstructure:"PASSWORD"`
+}
+
+const etcdClientConfKey = "payment_channel_storage_client"
+
+// defaultEtcdClientConf is used when no payment_channel_storage_client
+// section is present in the configuration, e.g. in tests which only start
+// an embedded server on the default endpoint.
+var defaultEtcdClientConf = EtcdClientConf{
+	ConnectionTimeout: 5 * time.Second,
+	RequestTimeout:    3 * time.Second,
+	Endpoints:         []string{"http://127.0.0.1:2379"},
+}
+
+// EtcdClient is a wrapper around etcd clientv3.Client which exposes only
+// the operations needed by the payment channel storage.
+type EtcdClient struct {
+	conf    *EtcdClientConf
+	timeout time.Duration
+	etcdv3  *clientv3.Client
+}
+
+// NewEtcdClient creates a new EtcdClient using the default configuration.
+// It is primarily useful in tests which need an additional client
+// connected to an already running embedded server.
+func NewEtcdClient() (client *EtcdClient, err error) {
+	return newEtcdClient(&defaultEtcdClientConf)
+}
+
+// NewEtcdClientFromVip creates a new EtcdClient using the
+// payment_channel_storage_client section of the passed Viper configuration.
+func NewEtcdClientFromVip(vip *viper.Viper) (client *EtcdClient, err error) {
+	conf, err := getEtcdClientConf(vip)
+	if err != nil {
+		return
+	}
+	return newEtcdClient(conf)
+}
+
+func getEtcdClientConf(vip *viper.Viper) (conf *EtcdClientConf, err error) {
+	conf = &EtcdClientConf{}
+	*conf = defaultEtcdClientConf
+	err = vip.UnmarshalKey(etcdClientConfKey, conf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %v config: %v", etcdClientConfKey, err)
+	}
+	return
+}
+
+func newEtcdClient(conf *EtcdClientConf) (client *EtcdClient, err error) {
+
+	tlsConfig, err := getClientTLSConfig(conf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build etcd client TLS config: %v", err)
+	}
+
+	etcdv3, err := clientv3.New(clientv3.Config{
+		Endpoints:   conf.Endpoints,
+		DialTimeout: conf.ConnectionTimeout,
+		TLS:         tlsConfig,
+		Username:    conf.Username,
+		Password:    conf.Password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create etcd client: %v", err)
+	}
+
+	client = &EtcdClient{
+		conf:    conf,
+		timeout: conf.RequestTimeout,
+		etcdv3:  etcdv3,
+	}
+	return
+}
+
+// getClientTLSConfig returns nil when none of the TLS fields are set, so
+// that plain http:// endpoints keep working exactly as before.
+func getClientTLSConfig(conf *EtcdClientConf) (config *tls.Config, err error) {
+	if conf.CAFile == "" && conf.CertFile == "" && conf.KeyFile == "" {
+		return nil, nil
+	}
+
+	tlsInfo := transport.TLSInfo{
+		TrustedCAFile: conf.CAFile,
+		CertFile:      conf.CertFile,
+		KeyFile:       conf.KeyFile,
+	}
+	return tlsInfo.ClientConfig()
+}
+
+// Close releases resources taken by the client.
+func (client *EtcdClient) Close() {
+	client.etcdv3.Close()
+}
+
+// Get returns the value for key and true if the key was found.
+func (client *EtcdClient) Get(key string) (value string, ok bool, err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	response, err := client.etcdv3.Get(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if len(response.Kvs) == 0 {
+		return "", false, nil
+	}
+	return string(response.Kvs[0].Value), true, nil
+}
+
+// GetWithVersion returns the value for key together with its etcd version,
+// so callers can later use CompareVersion instead of re-sending (and
+// re-comparing) the whole value.
+func (client *EtcdClient) GetWithVersion(key string) (value string, version int64, ok bool, err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	response, err := client.etcdv3.Get(ctx, key)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if len(response.Kvs) == 0 {
+		return "", 0, false, nil
+	}
+	return string(response.Kvs[0].Value), response.Kvs[0].Version, true, nil
+}
+
+// GetByKeyPrefix returns the values of all keys sharing keyPrefix.
+func (client *EtcdClient) GetByKeyPrefix(keyPrefix string) (values []string, ok bool, err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	response, err := client.etcdv3.Get(ctx, keyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, false, err
+	}
+	if len(response.Kvs) == 0 {
+		return nil, false, nil
+	}
+
+	values = make([]string, 0, len(response.Kvs))
+	for _, kv := range response.Kvs {
+		values = append(values, string(kv.Value))
+	}
+	return values, true, nil
+}
+
+// Put unconditionally stores value under key.
+func (client *EtcdClient) Put(key string, value string) (err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	if _, triggered := evalFailpoint("etcddb/beforePutCommit"); triggered {
+		return nil
+	}
+
+	_, err = client.etcdv3.Put(ctx, key, value)
+	return
+}
+
+// Delete removes key. It is not an error if key does not exist.
+func (client *EtcdClient) Delete(key string) (err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	_, err = client.etcdv3.Delete(ctx, key)
+	return
+}
+
+// PutIfAbsent stores value under key only if key does not exist yet.
+func (client *EtcdClient) PutIfAbsent(key string, value string) (ok bool, err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	response, err := client.etcdv3.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return response.Succeeded, nil
+}
+
+// CompareAndSwap stores newValue under key only if the current value is
+// exactly prevValue.
+func (client *EtcdClient) CompareAndSwap(key string, prevValue string, newValue string) (ok bool, err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	evalFailpoint("etcddb/beforeTxnCommit")
+
+	response, err := client.etcdv3.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", prevValue)).
+		Then(clientv3.OpPut(key, newValue)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	evalFailpoint("etcddb/afterTxnCommit")
+
+	return response.Succeeded, nil
+}
+
+// CompareAndDelete removes key only if the current value is exactly
+// expect, e.g. to implement a "close-if-still-open" style settlement.
+func (client *EtcdClient) CompareAndDelete(key string, expect string) (ok bool, err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	evalFailpoint("etcddb/beforeTxnCommit")
+
+	response, err := client.etcdv3.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", expect)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	evalFailpoint("etcddb/afterTxnCommit")
+
+	return response.Succeeded, nil
+}
+
+// Transaction atomically replaces the keys in expect by the values in swap.
+// Keys present only in expect are left untouched, keys present only in
+// swap are created. The whole transaction is rejected (ok == false) if any
+// key in expect does not currently hold the expected value.
+func (client *EtcdClient) Transaction(expect map[string]string, swap map[string]string) (ok bool, err error) {
+	ctx, cancel := client.context()
+	defer cancel()
+
+	compares := make([]clientv3.Cmp, 0, len(expect))
+	for key, value := range expect {
+		compares = append(compares, clientv3.Compare(clientv3.Value(key), "=", value))
+	}
+
+	ops := make([]clientv3.Op, 0, len(swap))
+	for key, value := range swap {
+		ops = append(ops, clientv3.OpPut(key, value))
+	}
+
+	evalFailpoint("etcddb/beforeTxnCommit")
+
+	response, err := client.etcdv3.Txn(ctx).If(compares...).Then(ops...).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	evalFailpoint("etcddb/afterTxnCommit")
+
+	return response.Succeeded, nil
+}
+
+// EtcdMutex is a distributed mutex built on top of an etcd lease-backed
+// concurrency session. A new session/mutex pair must be created per
+// goroutine/client, mirroring clientv3/concurrency semantics.
+type EtcdMutex struct {
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+// NewMutex creates a distributed mutex locked on key. The returned mutex is
+// not locked yet, call Lock to acquire it.
+func (client *EtcdClient) NewMutex(key string) (m *EtcdMutex, err error) {
+	session, err := concurrency.NewSession(client.etcdv3)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create etcd session: %v", err)
+	}
+
+	return &EtcdMutex{
+		session: session,
+		mutex:   concurrency.NewMutex(session, "/"+key),
+	}, nil
+}
+
+// Lock blocks until the mutex is acquired or ctx is done.
+func (m *EtcdMutex) Lock(ctx context.Context) (err error) {
+	evalFailpoint("etcddb/beforeMutexLock")
+	return m.mutex.Lock(ctx)
+}
+
+// Unlock releases the mutex and closes the underlying session.
+func (m *EtcdMutex) Unlock(ctx context.Context) (err error) {
+	defer m.session.Close()
+	return m.mutex.Unlock(ctx)
+}
+
+func (client *EtcdClient) context() (ctx context.Context, cancel context.CancelFunc) {
+	return context.WithTimeout(context.Background(), client.timeout)
+}
@@ -0,0 +1,159 @@
+package etcddb
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.etcd.io/etcd/embed"
+	"go.etcd.io/etcd/pkg/transport"
+)
+
+// EtcdServerConf keeps the configuration for the embedded etcd server which
+// snet-daemon can run to back the payment channel storage.
+type EtcdServerConf struct {
+	ID         string `json:"id" mapstructure:"ID"`
+	Host       string `json:"host" mapstructure:"HOST"`
+	ClientPort int    `json:"client_port" mapstructure:"CLIENT_PORT"`
+	PeerPort   int    `json:"peer_port" mapstructure:"PEER_PORT"`
+	Token      string `json:"token" mapstructure:"TOKEN"`
+	Cluster    string `json:"cluster" mapstructure:"CLUSTER"`
+	DataDir    string `json:"data_dir" mapstructure:"DATA_DIR"`
+	Enabled    bool   `json:"enabled" mapstructure:"ENABLED"`
+
+	// CAFile, CertFile and KeyFile enable peer and client TLS on the
+	// embedded server. ClientCertAuth requires clients to present a
+	// certificate signed by CAFile, AutoTLS generates a self-signed
+	// certificate on startup instead (development only).
+	CAFile         string `json:"ca_file" mapstructure:"CA_FILE"`
+	CertFile       string `json:"cert_file" mapstructure:"CERT_FILE"`
+	KeyFile        string `json:"key_file" mapstructure:"KEY_FILE"`
+	ClientCertAuth bool   `json:"client_cert_auth" mapstructure:"CLIENT_CERT_AUTH"`
+	AutoTLS        bool   `json:"auto_tls" mapstructure:"AUTO_TLS"`
+}
+
+const etcdServerConfKey = "payment_channel_storage_server"
+
+var defaultEtcdServerConf = EtcdServerConf{
+	ID:         "storage-1",
+	Host:       "127.0.0.1",
+	ClientPort: 2379,
+	PeerPort:   2380,
+	Token:      "unique-token",
+	DataDir:    "storage-data-dir-1.etcd",
+	Enabled:    false,
+}
+
+// EtcdServer wraps an embedded etcd server instance.
+type EtcdServer struct {
+	conf   *EtcdServerConf
+	server *embed.Etcd
+}
+
+// GetEtcdServerFromVip builds an EtcdServer from the
+// payment_channel_storage_server section of the passed Viper configuration.
+// The server is not started yet, call Start to bring it up.
+func GetEtcdServerFromVip(vip *viper.Viper) (server *EtcdServer, err error) {
+	conf, err := getEtcdServerConf(vip)
+	if err != nil {
+		return
+	}
+	if !conf.Enabled {
+		return nil, nil
+	}
+	return &EtcdServer{conf: conf}, nil
+}
+
+// InitEtcdServer builds an EtcdServer from vip and starts it in one call,
+// for callers which do not need to observe the pre-start configuration.
+func InitEtcdServer(vip *viper.Viper) (server *EtcdServer, err error) {
+	server, err = GetEtcdServerFromVip(vip)
+	if err != nil || server == nil {
+		return
+	}
+	err = server.Start()
+	return
+}
+
+func getEtcdServerConf(vip *viper.Viper) (conf *EtcdServerConf, err error) {
+	conf = &EtcdServerConf{}
+	*conf = defaultEtcdServerConf
+	err = vip.UnmarshalKey(etcdServerConfKey, conf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %v config: %v", etcdServerConfKey, err)
+	}
+	return
+}
+
+// Start brings up the embedded etcd server and blocks until it is ready to
+// serve client requests.
+func (server *EtcdServer) Start() (err error) {
+
+	conf := server.conf
+	cfg := embed.NewConfig()
+	cfg.Name = conf.ID
+	cfg.Dir = conf.DataDir
+	cfg.InitialClusterToken = conf.Token
+	cfg.InitialCluster = conf.Cluster
+
+	// The scheme advertised in LPUrls/APUrls must match the scheme used in
+	// InitialCluster, and clients must dial the scheme the listener
+	// actually speaks, so both are derived from whether TLS is configured
+	// rather than hardcoded to http.
+	scheme := "http"
+	if conf.CAFile != "" || conf.CertFile != "" || conf.AutoTLS {
+		scheme = "https"
+	}
+
+	clientURL, err := url.Parse(fmt.Sprintf("%v://%v:%v", scheme, conf.Host, conf.ClientPort))
+	if err != nil {
+		return fmt.Errorf("unable to parse client URL: %v", err)
+	}
+	peerURL, err := url.Parse(fmt.Sprintf("%v://%v:%v", scheme, conf.Host, conf.PeerPort))
+	if err != nil {
+		return fmt.Errorf("unable to parse peer URL: %v", err)
+	}
+
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.ACUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+	cfg.APUrls = []url.URL{*peerURL}
+
+	cfg.ClientTLSInfo = transport.TLSInfo{
+		TrustedCAFile:  conf.CAFile,
+		CertFile:       conf.CertFile,
+		KeyFile:        conf.KeyFile,
+		ClientCertAuth: conf.ClientCertAuth,
+	}
+	cfg.PeerTLSInfo = transport.TLSInfo{
+		TrustedCAFile:  conf.CAFile,
+		CertFile:       conf.CertFile,
+		KeyFile:        conf.KeyFile,
+		ClientCertAuth: conf.ClientCertAuth,
+	}
+	cfg.ClientAutoTLS = conf.AutoTLS
+	cfg.PeerAutoTLS = conf.AutoTLS
+
+	etcd, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to start embedded etcd server: %v", err)
+	}
+
+	select {
+	case <-etcd.Server.ReadyNotify():
+	case <-time.After(60 * time.Second):
+		etcd.Server.Stop()
+		return fmt.Errorf("embedded etcd server took too long to start")
+	}
+
+	server.server = etcd
+	return nil
+}
+
+// Close stops the embedded etcd server.
+func (server *EtcdServer) Close() {
+	if server.server != nil {
+		server.server.Close()
+	}
+}
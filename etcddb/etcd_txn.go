@@ -0,0 +1,114 @@
+package etcddb
+
+import (
+	"go.etcd.io/etcd/clientv3"
+)
+
+// Cmp is a single comparison used as a condition in a Txn. Build one with
+// CompareValue, CompareVersion or CompareModRevision.
+type Cmp struct {
+	cmp clientv3.Cmp
+}
+
+// CompareValue builds a Cmp checking key's stored value against op (one of
+// "=", "!=", "<", ">") and value.
+func CompareValue(key string, op string, value string) Cmp {
+	return Cmp{cmp: clientv3.Compare(clientv3.Value(key), op, value)}
+}
+
+// CompareVersion builds a Cmp checking key's version, which increments on
+// every write and resets to 0 on delete. Comparing on version instead of
+// value avoids re-serializing and shipping a large stored value just to
+// check it hasn't changed.
+func CompareVersion(key string, op string, version int64) Cmp {
+	return Cmp{cmp: clientv3.Compare(clientv3.Version(key), op, version)}
+}
+
+// CompareModRevision builds a Cmp checking key's mod revision, the
+// cluster-wide revision at which it was last modified.
+func CompareModRevision(key string, op string, revision int64) Cmp {
+	return Cmp{cmp: clientv3.Compare(clientv3.ModRevision(key), op, revision)}
+}
+
+// Op is a single operation executed as part of a Txn's Then/Else branch.
+// Build one with OpPut, OpDelete or OpGet.
+type Op struct {
+	op clientv3.Op
+}
+
+// OpPut builds an Op storing value under key.
+func OpPut(key string, value string) Op {
+	return Op{op: clientv3.OpPut(key, value)}
+}
+
+// OpDelete builds an Op removing key.
+func OpDelete(key string) Op {
+	return Op{op: clientv3.OpDelete(key)}
+}
+
+// OpGet builds an Op reading key as part of the transaction. Commit only
+// reports which branch ran; the values read by OpGet are not returned.
+func OpGet(key string) Op {
+	return Op{op: clientv3.OpGet(key)}
+}
+
+// Txn is a fluent builder around an etcd multi-op transaction: conditions
+// added with If are evaluated atomically, the Then ops run if all of them
+// hold, the Else ops run otherwise.
+type Txn struct {
+	client  *EtcdClient
+	cmps    []clientv3.Cmp
+	thenOps []clientv3.Op
+	elseOps []clientv3.Op
+}
+
+// Txn starts a new transaction builder against client.
+func (client *EtcdClient) Txn() *Txn {
+	return &Txn{client: client}
+}
+
+// If adds conditions to the transaction. Multiple calls accumulate.
+func (txn *Txn) If(cmps ...Cmp) *Txn {
+	for _, cmp := range cmps {
+		txn.cmps = append(txn.cmps, cmp.cmp)
+	}
+	return txn
+}
+
+// Then adds operations run when every If condition holds.
+func (txn *Txn) Then(ops ...Op) *Txn {
+	for _, op := range ops {
+		txn.thenOps = append(txn.thenOps, op.op)
+	}
+	return txn
+}
+
+// Else adds operations run when any If condition fails.
+func (txn *Txn) Else(ops ...Op) *Txn {
+	for _, op := range ops {
+		txn.elseOps = append(txn.elseOps, op.op)
+	}
+	return txn
+}
+
+// Commit executes the transaction. ok reports whether the If conditions
+// held, i.e. whether the Then branch (rather than Else) ran.
+func (txn *Txn) Commit() (ok bool, err error) {
+	ctx, cancel := txn.client.context()
+	defer cancel()
+
+	evalFailpoint("etcddb/beforeTxnCommit")
+
+	response, err := txn.client.etcdv3.Txn(ctx).
+		If(txn.cmps...).
+		Then(txn.thenOps...).
+		Else(txn.elseOps...).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	evalFailpoint("etcddb/afterTxnCommit")
+
+	return response.Succeeded, nil
+}
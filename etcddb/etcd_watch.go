@@ -0,0 +1,154 @@
+package etcddb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// WatchEventType tells whether a WatchEvent is a put or a delete.
+type WatchEventType int
+
+const (
+	// WatchEventPut is emitted on key creation and on every update.
+	WatchEventPut WatchEventType = iota
+	// WatchEventDelete is emitted when the key is removed.
+	WatchEventDelete
+)
+
+// WatchEvent reports a single change observed on a watched key.
+type WatchEvent struct {
+	Type  WatchEventType
+	Key   string
+	Value string
+	// ModRevision can be passed back via WatchFromRevision to continue
+	// watching after this event without missing or re-delivering updates.
+	ModRevision int64
+}
+
+// watchReconnectDelay is the backoff between retries when the underlying
+// etcd watch stream is dropped, e.g. because of a leader election.
+const watchReconnectDelay = 500 * time.Millisecond
+
+// watchEstablishTimeout bounds how long Watch/WatchByPrefix wait for the
+// watch to actually be registered with the etcd server before returning,
+// so callers can safely Put/Delete right after without racing the watch's
+// own setup.
+const watchEstablishTimeout = 5 * time.Second
+
+// Watch streams changes to key until ctx is done. The returned channel is
+// closed when ctx is done; a dropped connection is retried transparently
+// and does not close the channel. Watch only returns once the watch is
+// confirmed registered with the etcd server, so a Put immediately after
+// Watch returns is guaranteed to be observed.
+func (client *EtcdClient) Watch(ctx context.Context, key string) (<-chan WatchEvent, error) {
+	return client.watch(ctx, key, false, 0)
+}
+
+// WatchByPrefix is like Watch but streams changes to every key sharing
+// keyPrefix.
+func (client *EtcdClient) WatchByPrefix(ctx context.Context, keyPrefix string) (<-chan WatchEvent, error) {
+	return client.watch(ctx, keyPrefix, true, 0)
+}
+
+// WatchFromRevision resumes a previously interrupted watch: events with a
+// ModRevision up to and including fromRevision are assumed to have already
+// been delivered and are skipped. fromRevision == 0 behaves like Watch.
+func (client *EtcdClient) WatchFromRevision(ctx context.Context, key string, fromRevision int64) (<-chan WatchEvent, error) {
+	return client.watch(ctx, key, false, fromRevision)
+}
+
+// WatchByPrefixFromRevision is the WatchFromRevision counterpart of
+// WatchByPrefix.
+func (client *EtcdClient) WatchByPrefixFromRevision(ctx context.Context, keyPrefix string, fromRevision int64) (<-chan WatchEvent, error) {
+	return client.watch(ctx, keyPrefix, true, fromRevision)
+}
+
+func (client *EtcdClient) watch(ctx context.Context, key string, byPrefix bool, fromRevision int64) (<-chan WatchEvent, error) {
+
+	events := make(chan WatchEvent)
+	established := make(chan struct{})
+
+	go func() {
+		defer close(events)
+
+		revision := fromRevision
+		awaitingEstablish := true
+
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+
+			opts := []clientv3.OpOption{clientv3.WithCreatedNotify()}
+			if byPrefix {
+				opts = append(opts, clientv3.WithPrefix())
+			}
+			if revision > 0 {
+				opts = append(opts, clientv3.WithRev(revision+1))
+			}
+
+			watchChan := client.etcdv3.Watch(ctx, key, opts...)
+
+			for response := range watchChan {
+				if response.Err() != nil {
+					log.WithError(response.Err()).Warn("etcd watch stream error, reconnecting")
+					break
+				}
+
+				if response.Created && awaitingEstablish {
+					awaitingEstablish = false
+					close(established)
+				}
+
+				for _, watchEvent := range response.Events {
+					revision = watchEvent.Kv.ModRevision
+
+					event := WatchEvent{
+						Key:         string(watchEvent.Kv.Key),
+						Value:       string(watchEvent.Kv.Value),
+						ModRevision: watchEvent.Kv.ModRevision,
+					}
+					if watchEvent.Type == mvccpb.DELETE {
+						event.Type = WatchEventDelete
+					} else {
+						event.Type = WatchEventPut
+					}
+
+					select {
+					case events <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			// watchChan was closed, either because ctx is done or because
+			// the connection to etcd was lost; back off before retrying so
+			// a flapping leader election does not spin the client. Resume
+			// is keyed on the last seen ModRevision, so a restart of the
+			// etcd server mid-stream does not lose or replay events.
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-time.After(watchReconnectDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-established:
+		return events, nil
+	case <-ctx.Done():
+		return events, nil
+	case <-time.After(watchEstablishTimeout):
+		return events, fmt.Errorf("timed out waiting for watch on %v to be established", key)
+	}
+}
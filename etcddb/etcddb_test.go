@@ -11,6 +11,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"go.etcd.io/etcd/pkg/transport"
 )
 
 // TODO: initialize client and server only once to make test faster
@@ -358,3 +359,246 @@ func removeWorkDir(t *testing.T, workDir string) {
 	err = os.RemoveAll(dir + "/" + workDir)
 	assert.Nil(t, err)
 }
+
+func (suite *EtcdTestSuite) TestEtcdCompareAndDelete() {
+
+	t := suite.T()
+	client := suite.client
+
+	key := "key-cad"
+	value := "value-cad"
+
+	err := client.Put(key, value)
+	assert.Nil(t, err)
+
+	ok, err := client.CompareAndDelete(key, "wrong-value")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = client.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, ok, "delete must not have happened on a failed comparison")
+
+	ok, err = client.CompareAndDelete(key, value)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = client.Get(key)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// A second CompareAndDelete race loses: the key is already gone so the
+	// value comparison can never hold again.
+	ok, err = client.CompareAndDelete(key, value)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func (suite *EtcdTestSuite) TestEtcdVersionBasedCAS() {
+
+	t := suite.T()
+	client := suite.client
+
+	key := "key-version-cas"
+
+	err := client.Put(key, "v1")
+	assert.Nil(t, err)
+
+	value, version, ok, err := client.GetWithVersion(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v1", value)
+
+	ok, err = client.Txn().
+		If(CompareVersion(key, "=", version)).
+		Then(OpPut(key, "v2")).
+		Commit()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// Reusing the now-stale version must fail: a concurrent writer already
+	// bumped it once.
+	ok, err = client.Txn().
+		If(CompareVersion(key, "=", version)).
+		Then(OpPut(key, "v3")).
+		Commit()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	value, ok, err = client.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v2", value)
+}
+
+func (suite *EtcdTestSuite) TestEtcdTxnThenElse() {
+
+	t := suite.T()
+	client := suite.client
+
+	key := "key-txn-then-else"
+	other := "key-txn-other"
+
+	err := client.Put(key, "expected")
+	assert.Nil(t, err)
+
+	ok, err := client.Txn().
+		If(CompareValue(key, "=", "not-expected")).
+		Then(OpPut(key, "then-value")).
+		Else(OpPut(other, "else-value"), OpDelete(key)).
+		Commit()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = client.Get(key)
+	assert.Nil(t, err)
+	assert.False(t, ok, "Else branch should have deleted key")
+
+	value, ok, err := client.Get(other)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "else-value", value)
+}
+
+func (suite *EtcdTestSuite) TestEtcdWatch() {
+
+	t := suite.T()
+	client := suite.client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.Watch(ctx, "watched-key")
+	assert.Nil(t, err)
+
+	err = client.Put("watched-key", "value-1")
+	assert.Nil(t, err)
+
+	event := <-events
+	assert.Equal(t, WatchEventPut, event.Type)
+	assert.Equal(t, "watched-key", event.Key)
+	assert.Equal(t, "value-1", event.Value)
+
+	err = client.Delete("watched-key")
+	assert.Nil(t, err)
+
+	event = <-events
+	assert.Equal(t, WatchEventDelete, event.Type)
+}
+
+// TestEtcdWatchSurvivesServerRestart simulates a leader switch by stopping
+// and restarting the embedded server mid-stream, and asserts the watch
+// goroutine reconnects and keeps delivering events without the caller
+// having to re-establish anything.
+func (suite *EtcdTestSuite) TestEtcdWatchSurvivesServerRestart() {
+
+	t := suite.T()
+	client := suite.client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.Watch(ctx, "restart-key")
+	assert.Nil(t, err)
+
+	err = client.Put("restart-key", "before-restart")
+	assert.Nil(t, err)
+
+	event := <-events
+	assert.Equal(t, "before-restart", event.Value)
+
+	suite.server.Close()
+	err = suite.server.Start()
+	assert.Nil(t, err)
+
+	// The server restart drops the watch stream; give the client's
+	// reconnect loop a moment to notice before the new client call lands.
+	time.Sleep(2 * watchReconnectDelay)
+
+	err = client.Put("restart-key", "after-restart")
+	assert.Nil(t, err)
+
+	select {
+	case event = <-events:
+		assert.Equal(t, "after-restart", event.Value)
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch did not resume delivering events after server restart")
+	}
+}
+
+// TestEtcdTLSAndRBAC starts the embedded server with a self-signed
+// certificate and a non-root user scoped to a key prefix, then verifies
+// that the existing Put/Get/CompareAndSwap operations still work over the
+// encrypted, authenticated connection.
+func TestEtcdTLSAndRBAC(t *testing.T) {
+
+	workDir := "storage-data-dir-tls.etcd"
+	defer removeWorkDir(t, workDir)
+
+	certDir, err := os.MkdirTemp("", "etcddb-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(certDir)
+
+	tlsInfo, err := transport.SelfCert(certDir, []string{"127.0.0.1"}, 1)
+	assert.Nil(t, err)
+
+	confJSON := fmt.Sprintf(`
+	{
+		"payment_channel_storage_client": {
+			"connection_timeout": "5s",
+			"request_timeout": "3s",
+			"endpoints": ["https://127.0.0.1:12379"],
+			"ca_file": "%[1]s",
+			"cert_file": "%[2]s",
+			"key_file": "%[3]s",
+			"username": "daemon",
+			"password": "daemon-secret"
+		},
+
+		"payment_channel_storage_server": {
+			"id": "storage-tls",
+			"host" : "127.0.0.1",
+			"client_port": 12379,
+			"peer_port": 12380,
+			"token": "unique-token-tls",
+			"cluster": "storage-tls=https://127.0.0.1:12380",
+			"data_dir": "%[4]s",
+			"enabled": true,
+			"ca_file": "%[1]s",
+			"cert_file": "%[2]s",
+			"key_file": "%[3]s"
+		}
+	}`, tlsInfo.TrustedCAFile, tlsInfo.CertFile, tlsInfo.KeyFile, workDir)
+
+	vip := readConfig(t, confJSON)
+	server, err := GetEtcdServerFromVip(vip)
+	assert.Nil(t, err)
+	assert.NotNil(t, server)
+	defer server.Close()
+
+	err = server.Start()
+	assert.Nil(t, err)
+
+	rootClient, err := NewEtcdClientFromVip(vip)
+	assert.Nil(t, err)
+	defer rootClient.Close()
+
+	err = Bootstrap(rootClient, "daemon", "daemon-secret", "channel/", "root-secret")
+	assert.Nil(t, err)
+
+	client, err := NewEtcdClientFromVip(vip)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	err = client.Put("channel/1", "value")
+	assert.Nil(t, err)
+
+	value, ok, err := client.Get("channel/1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	ok, err = client.CompareAndSwap("channel/1", "value", "value-2")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,90 @@
+// Package failpoint provides a tiny runtime-toggleable fault injection
+// mechanism for the etcddb test suite, in the spirit of the gofail-based
+// e2e tests in the etcd project itself. Hooks compiled into etcddb under
+// the "failpoints" build tag call Eval at interesting points (before a
+// transaction commits, before a mutex lock is granted, ...) so tests can
+// arm a panic, a sleep or a canned return value without touching
+// production code paths when the tag is absent.
+package failpoint
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type action struct {
+	kind  string // "panic", "sleep" or "return"
+	sleep time.Duration
+	value string
+}
+
+var (
+	mutex    sync.RWMutex
+	registry = map[string]action{}
+)
+
+// Enable arms the named failpoint with spec, one of:
+//   - "panic"          : Eval panics when reached
+//   - "sleep(500ms)"   : Eval blocks for the given duration
+//   - "return(value)"  : Eval reports triggered=true with value
+func Enable(name string, spec string) error {
+	act, err := parse(spec)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	registry[name] = act
+	return nil
+}
+
+// Disable disarms name. It is a no-op if name was not armed.
+func Disable(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(registry, name)
+}
+
+func parse(spec string) (act action, err error) {
+	switch {
+	case spec == "panic":
+		return action{kind: "panic"}, nil
+	case strings.HasPrefix(spec, "sleep(") && strings.HasSuffix(spec, ")"):
+		duration, err := time.ParseDuration(spec[len("sleep(") : len(spec)-1])
+		if err != nil {
+			return action{}, fmt.Errorf("invalid sleep failpoint %q: %v", spec, err)
+		}
+		return action{kind: "sleep", sleep: duration}, nil
+	case strings.HasPrefix(spec, "return(") && strings.HasSuffix(spec, ")"):
+		return action{kind: "return", value: spec[len("return(") : len(spec)-1]}, nil
+	}
+	return action{}, fmt.Errorf("unrecognized failpoint spec %q", spec)
+}
+
+// Eval triggers the failpoint registered under name, if any. For a "panic"
+// failpoint it panics; for a "sleep" failpoint it blocks for the armed
+// duration and reports triggered=false; for a "return" failpoint it
+// reports the armed value with triggered=true so the caller can
+// short-circuit its normal logic.
+func Eval(name string) (value string, triggered bool) {
+	mutex.RLock()
+	act, ok := registry[name]
+	mutex.RUnlock()
+	if !ok {
+		return "", false
+	}
+
+	switch act.kind {
+	case "panic":
+		panic("failpoint " + name + " triggered panic")
+	case "sleep":
+		time.Sleep(act.sleep)
+		return "", false
+	case "return":
+		return act.value, true
+	}
+	return "", false
+}
@@ -0,0 +1,12 @@
+//go:build failpoints
+
+package etcddb
+
+import "github.com/singnet/snet-daemon/etcddb/failpoint"
+
+// evalFailpoint is only wired up to the real failpoint registry when the
+// package is built with the "failpoints" tag, so production binaries never
+// pay for the lookup.
+func evalFailpoint(name string) (value string, triggered bool) {
+	return failpoint.Eval(name)
+}
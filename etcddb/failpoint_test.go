@@ -0,0 +1,166 @@
+//go:build failpoints
+
+package etcddb
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/singnet/snet-daemon/etcddb/failpoint"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFailpointAfterTxnCommit injects a crash between the etcd commit and
+// the client observing its result. The stored value must still reflect the
+// write: escrow reconciliation relies on being able to re-read the stored
+// state and treat a "lost" response as success, not as a reason to retry
+// the swap and risk a double update.
+func (suite *EtcdTestSuite) TestFailpointAfterTxnCommit() {
+
+	t := suite.T()
+	client := suite.client
+
+	key, expect, update := "failpoint-cas-key", "expect", "update"
+
+	err := client.Put(key, expect)
+	assert.Nil(t, err)
+
+	err = failpoint.Enable("etcddb/afterTxnCommit", "panic")
+	assert.Nil(t, err)
+	defer failpoint.Disable("etcddb/afterTxnCommit")
+
+	assert.Panics(t, func() {
+		client.CompareAndSwap(key, expect, update)
+	})
+
+	failpoint.Disable("etcddb/afterTxnCommit")
+
+	value, ok, err := client.Get(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, update, value, "the commit that 'crashed' the client must still have landed")
+}
+
+// TestFailpointMutexLockUnderLatency stalls every lock attempt briefly to
+// simulate a slow lease renewal, and asserts the TestEtcdMutex invariant
+// (every critical section observes a consistent pair of writes) still
+// holds when lock acquisition is slow rather than instantaneous.
+func (suite *EtcdTestSuite) TestFailpointMutexLockUnderLatency() {
+
+	t := suite.T()
+
+	err := failpoint.Enable("etcddb/beforeMutexLock", "sleep(50ms)")
+	assert.Nil(t, err)
+	defer failpoint.Disable("etcddb/beforeMutexLock")
+
+	keyA, keyB, lockKey := "failpoint-key-a", "failpoint-key-b", "failpoint-lock"
+
+	n := 4
+	var wg sync.WaitGroup
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			client, err := NewEtcdClient()
+			assert.Nil(t, err)
+			defer client.Close()
+
+			mutex, err := client.NewMutex(lockKey)
+			assert.Nil(t, err)
+			defer mutex.Unlock(context.Background())
+
+			err = mutex.Lock(context.Background())
+			assert.Nil(t, err)
+
+			value := strconv.Itoa(i)
+
+			err = client.Put(keyA, value)
+			assert.Nil(t, err)
+			time.Sleep(10 * time.Millisecond)
+			err = client.Put(keyB, value)
+			assert.Nil(t, err)
+		}(i)
+	}
+
+	wg.Wait()
+
+	valueA, ok, err := suite.client.Get(keyA)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	valueB, ok, err := suite.client.Get(keyB)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, valueA, valueB)
+}
+
+// TestFailpointNetworkPartitionDuringPut simulates a partition that swallows
+// a write after the client has already decided to send it: beforePutCommit
+// makes Put report success without the value ever reaching etcd, exactly
+// what a client sees when a partition drops the request on the wire. It
+// asserts Put's return value alone cannot be trusted as proof of
+// durability, which is why reconciliation elsewhere (e.g. escrow's use of
+// CompareAndSwap) re-reads state instead of assuming a prior Put landed.
+func (suite *EtcdTestSuite) TestFailpointNetworkPartitionDuringPut() {
+
+	t := suite.T()
+	client := suite.client
+
+	key := "failpoint-partition-key"
+
+	err := failpoint.Enable("etcddb/beforePutCommit", "return()")
+	assert.Nil(t, err)
+	defer failpoint.Disable("etcddb/beforePutCommit")
+
+	err = client.Put(key, "value")
+	assert.Nil(t, err, "a partitioned write still reports success to the caller")
+
+	failpoint.Disable("etcddb/beforePutCommit")
+
+	_, ok, err := client.Get(key)
+	assert.Nil(t, err)
+	assert.False(t, ok, "the write must not actually have reached etcd while the failpoint was armed")
+}
+
+// TestFailpointNetworkPartitionDuringWatch simulates a partition between the
+// client and the etcd cluster by stopping the embedded server mid-stream,
+// before any event was delivered, and starting it again after a couple of
+// reconnect backoff rounds. This is a full stop and restart of the server,
+// not a pause that keeps it running: the watch goroutine must back off and
+// resume once the server is reachable again, without the caller having to
+// re-establish anything.
+func (suite *EtcdTestSuite) TestFailpointNetworkPartitionDuringWatch() {
+
+	t := suite.T()
+	client := suite.client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := client.Watch(ctx, "partition-key")
+	assert.Nil(t, err)
+
+	suite.server.Close()
+
+	// The client's watch retries on a fixed backoff while the server is
+	// unreachable; give it a couple of rounds before bringing the server
+	// back so the reconnect path, not just the initial connection, is
+	// exercised.
+	time.Sleep(2 * watchReconnectDelay)
+
+	err = suite.server.Start()
+	assert.Nil(t, err)
+
+	err = client.Put("partition-key", "value")
+	assert.Nil(t, err)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "value", event.Value)
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch did not resume delivering events once the partition healed")
+	}
+}